@@ -0,0 +1,236 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"errors"
+	"time"
+
+	"context"
+
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/types"
+)
+
+// defaultTrashPrefix is the object-name prefix under which GC writes a
+// tombstone for each table it drops from the manifest. The dropped table
+// object itself is neither moved nor deleted, so a reader holding an
+// already-open reference to an older manifest snapshot can still read it;
+// nothing in this package deletes it later.
+const defaultTrashPrefix = "trash/"
+
+// GCOptions tunes how aggressively, and how much, a single GC call does, so
+// GC can run incrementally against a store that's serving live traffic.
+type GCOptions struct {
+	// DryRun computes and returns what GC would reclaim without touching
+	// the manifest or any table.
+	DryRun bool
+	// MinDeadRatio is the fraction of a table's chunks (by count) that must
+	// be dead before GC bothers rewriting it. Tables below the threshold
+	// are left alone even if they do contain some dead chunks -- rewriting
+	// a mostly-live table to drop a handful of dead chunks usually isn't
+	// worth the write amplification.
+	MinDeadRatio float64
+	// MaxBytesRewritten caps how many bytes of tables GC will rewrite in a
+	// single call, so a large backlog of dead chunks can be swept across
+	// several incremental invocations instead of one long one.
+	MaxBytesRewritten uint64
+	// TrashGracePeriod sets the time each dropped table's tombstone
+	// records: now plus TrashGracePeriod, the earliest time the table may
+	// be deleted for good.
+	TrashGracePeriod time.Duration
+	// TrashPrefix overrides the object-name prefix dropped tables'
+	// tombstones are written under. Defaults to defaultTrashPrefix.
+	TrashPrefix string
+}
+
+func (opts GCOptions) orDefault() GCOptions {
+	if opts.MinDeadRatio == 0 {
+		opts.MinDeadRatio = 0.5
+	}
+	if opts.MaxBytesRewritten == 0 {
+		opts.MaxBytesRewritten = 1 << 30 // 1GB
+	}
+	if opts.TrashGracePeriod == 0 {
+		opts.TrashGracePeriod = 24 * time.Hour
+	}
+	if opts.TrashPrefix == "" {
+		opts.TrashPrefix = defaultTrashPrefix
+	}
+	return opts
+}
+
+// GCStats summarizes what a GC call found and did.
+type GCStats struct {
+	LiveChunks      uint64
+	TablesRewritten int
+	TablesTrashed   int
+	BytesRewritten  uint64
+}
+
+// GC reclaims tables that hold chunks no longer reachable from the current
+// root. It marks the live set by traversing chunks from the root (decoding
+// each chunk's outgoing refs via extractChunks-style access, not via
+// caller-supplied hints, since GC must not trust anything but the graph
+// itself), rewrites tables that mix live and dead chunks into new,
+// live-only tables, and atomically updates the manifest to drop tables
+// that end up entirely dead. Each dropped table gets a tombstone under
+// opts.TrashPrefix recording when opts.TrashGracePeriod runs out; the table
+// objects themselves are neither moved nor deleted, so concurrent readers
+// finish cleanly. GC can be called repeatedly against a live
+// store: opts.MaxBytesRewritten bounds how much rewriting one call does,
+// so a large store can be swept incrementally.
+//
+// The root and table list GC scores dead chunks against are read together,
+// once, at the start of the call (rather than via nbs.Root()/nbs.tables
+// separately, which could observe a root and a table list that a
+// concurrent UpdateRoot never committed together). The manifest swap at
+// the end re-checks that the table list hasn't moved on from that snapshot
+// -- if a concurrent UpdateRoot committed a new table in the meantime,
+// DropAndTrash fails and GC returns an error rather than risk trashing a
+// table that might hold chunks live under the newer root. Callers should
+// simply retry GC in that case.
+func (nbs *NomsBlockStore) GC(ctx context.Context, opts GCOptions) (GCStats, error) {
+	opts = opts.orDefault()
+
+	exists, _, root, specs := nbs.mm.ParseIfExists(ctx, nil)
+	if !exists {
+		return GCStats{}, nil
+	}
+
+	live, err := nbs.markLiveChunks(ctx, root)
+	if err != nil {
+		return GCStats{}, err
+	}
+
+	nbs.mu.RLock()
+	tables := nbs.tables
+	nbs.mu.RUnlock()
+
+	gcts, ok := tables.(gcTableSet)
+	if !ok {
+		return GCStats{}, errors.New("nbs: this store's tableSet does not support GC")
+	}
+	gcmm, ok := nbs.mm.(gcManifest)
+	if !ok {
+		return GCStats{}, errors.New("nbs: this store's manifest does not support GC")
+	}
+
+	var stats GCStats
+	var keep, rewritten, trashed []tableSpec
+	var bytesRewritten uint64
+
+	for _, spec := range specs {
+		if err := ctx.Err(); err != nil {
+			return GCStats{}, err
+		}
+
+		deadRatio, tableBytes, err := gcts.deadRatio(ctx, spec, live)
+		if err != nil {
+			return GCStats{}, err
+		}
+
+		switch {
+		case deadRatio == 0:
+			keep = append(keep, spec)
+		case deadRatio == 1:
+			stats.TablesTrashed++
+			trashed = append(trashed, spec)
+		case deadRatio >= opts.MinDeadRatio && bytesRewritten+tableBytes <= opts.MaxBytesRewritten:
+			stats.TablesRewritten++
+			bytesRewritten += tableBytes
+			stats.BytesRewritten += tableBytes
+			if opts.DryRun {
+				keep = append(keep, spec)
+				continue
+			}
+			newSpec, err := gcts.rewriteLive(ctx, spec, live)
+			if err != nil {
+				return GCStats{}, err
+			}
+			trashed = append(trashed, spec)
+			rewritten = append(rewritten, newSpec)
+		default:
+			keep = append(keep, spec)
+		}
+	}
+
+	stats.LiveChunks = live.Count()
+	if opts.DryRun {
+		return stats, nil
+	}
+
+	keep = append(keep, rewritten...)
+
+	if err := gcmm.DropAndTrash(ctx, specs, keep, opts.TrashPrefix, opts.TrashGracePeriod); err != nil {
+		return GCStats{}, err
+	}
+
+	nbs.mu.Lock()
+	if fresh, ok := nbs.tables.(gcTableSet); ok {
+		nbs.tables = fresh.withoutSpecs(trashed).Union(keep)
+	} else {
+		nbs.tables = nbs.tables.Union(keep)
+	}
+	nbs.mu.Unlock()
+
+	return stats, nil
+}
+
+// markLiveChunks traverses the chunk graph reachable from root, returning
+// a liveSet of every chunk address found. This currently walks the
+// frontier one nbs.GetMany call per markFrontierBatchSize chunks, rather
+// than parallelizing across calls.
+//
+// Cycle detection during the walk uses an in-memory set of visited hashes
+// -- unlike the returned liveSet (where a false positive just means a dead
+// chunk survives a GC pass), falsely treating an unvisited chunk as
+// visited here would skip its descendants and wrongly mark them dead, so
+// this can't be bloom-filter-backed the same way. For graphs too large for
+// that to fit in memory, callers should shard GC by subtree rather than
+// run a single whole-store pass.
+const markFrontierBatchSize = 256
+
+func (nbs *NomsBlockStore) markLiveChunks(ctx context.Context, root hash.Hash) (*liveSet, error) {
+	live := newLiveSet(uint64(nbs.Count(ctx)))
+	if root.IsEmpty() {
+		return live, nil
+	}
+
+	visited := map[hash.Hash]struct{}{root: {}}
+	queue := []hash.Hash{root}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		batch := queue
+		if len(batch) > markFrontierBatchSize {
+			batch = batch[:markFrontierBatchSize]
+		}
+		queue = queue[len(batch):]
+
+		chunx, err := nbs.GetMany(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, c := range chunx {
+			if c.IsEmpty() {
+				continue
+			}
+			live.add(addr(batch[i]))
+
+			for _, ref := range types.ChunkRefs(c) {
+				if _, ok := visited[ref]; ok {
+					continue
+				}
+				visited[ref] = struct{}{}
+				queue = append(queue, ref)
+			}
+		}
+	}
+	return live, nil
+}
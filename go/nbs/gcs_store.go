@@ -0,0 +1,145 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/attic-labs/noms/go/chunks"
+	"google.golang.org/api/googleapi"
+)
+
+// gcsManifestName is the well-known object holding the manifest, mirroring
+// the row key dynamoManifest uses in DynamoDB.
+const gcsManifestName = "manifest"
+
+// gcsObjectStore adapts a *storage.Client/bucket pair to the objectStore
+// interface, using GCS object generation preconditions in place of
+// DynamoDB's conditional-update semantics for the manifest CAS.
+type gcsObjectStore struct {
+	bucket *storage.BucketHandle
+	ns     string
+}
+
+func (g gcsObjectStore) key(name string) string {
+	if g.ns == "" {
+		return name
+	}
+	return g.ns + "/" + name
+}
+
+func (g gcsObjectStore) ReadObject(ctx context.Context, name string) (data []byte, generation int64, ok bool, err error) {
+	r, err := g.bucket.Object(g.key(name)).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, 0, false, nil
+	} else if err != nil {
+		return nil, 0, false, err
+	}
+	defer r.Close()
+	generation = r.Attrs.Generation
+	data, err = ioutil.ReadAll(r)
+	return data, generation, err == nil, err
+}
+
+// ReadObjectRange reads a byte range out of name using GCS's range-read
+// support, so objectStoreTableSet's coalesced reads only pull the bytes a
+// table's index says they need rather than the whole object.
+func (g gcsObjectStore) ReadObjectRange(ctx context.Context, name string, offset, length uint64) (data []byte, ok bool, err error) {
+	r, err := g.bucket.Object(g.key(name)).NewRangeReader(ctx, int64(offset), int64(length))
+	if err == storage.ErrObjectNotExist {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	data, err = ioutil.ReadAll(r)
+	return data, err == nil, err
+}
+
+func (g gcsObjectStore) WriteObject(ctx context.Context, name string, data []byte) error {
+	w := g.bucket.Object(g.key(name)).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ConditionalWriteObject writes data to name iff the object's current
+// generation is ifGeneration, using GCS's GenerationMatch precondition, or
+// its DoesNotExist precondition when ifGeneration is 0.
+// A lost race surfaces from the GCS client as a 412 Precondition Failed,
+// which we translate into ok == false rather than an error.
+func (g gcsObjectStore) ConditionalWriteObject(ctx context.Context, name string, data []byte, ifGeneration int64) (ok bool, err error) {
+	cond := storage.Conditions{GenerationMatch: ifGeneration}
+	if ifGeneration == 0 {
+		cond = storage.Conditions{DoesNotExist: true}
+	}
+	obj := g.bucket.Object(g.key(name)).If(cond)
+	w := obj.NewWriter(ctx)
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return false, err
+	}
+	if err = w.Close(); err != nil {
+		if apiErr, is := err.(*googleapi.Error); is && apiErr.Code == http.StatusPreconditionFailed {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GCSStoreFactory vends NomsBlockStore instances backed by Google Cloud
+// Storage, mirroring AWSStoreFactory's contract: one store per namespace,
+// sharing a client, bucket and IndexCache across CreateStore calls.
+type GCSStoreFactory struct {
+	client     *storage.Client
+	bucket     string
+	indexCache IndexCache
+	readCfg    ReadConfig
+	wbCfg      WriteBackConfig
+}
+
+// NewGCSStoreFactory returns a chunks.Factory that creates GCS-backed
+// NomsBlockStore instances sharing client and bucket, analogous to
+// NewAWSStoreFactory. It accepts the same AWSStoreFactoryOptions so
+// IndexCache, read-path and write-back tuning work identically across both
+// backends.
+func NewGCSStoreFactory(client *storage.Client, bucket string, indexCacheSize uint64, opts ...AWSStoreFactoryOption) chunks.Factory {
+	asf := &AWSStoreFactory{}
+	for _, opt := range opts {
+		opt(asf)
+	}
+	indexCache := asf.indexCache
+	if indexCache == nil && indexCacheSize > 0 {
+		indexCache = newS3IndexCache(indexCacheSize)
+	}
+	return &GCSStoreFactory{client, bucket, indexCache, asf.readCfg, asf.wbCfg}
+}
+
+func (gsf *GCSStoreFactory) CreateStore(ns string) chunks.ChunkStore {
+	return newGCSStore(gsf.bucket, ns, gsf.client, defaultMemTableSize, gsf.indexCache, gsf.readCfg, gsf.wbCfg)
+}
+
+func (gsf *GCSStoreFactory) Shutter() {
+}
+
+// NewGCSStore creates a NomsBlockStore backed by the GCS bucket bucket,
+// namespaced under ns, using client for all GCS calls. It's the GCS
+// equivalent of NewAWSStore.
+func NewGCSStore(bucket, ns string, client *storage.Client, memTableSize uint64) *NomsBlockStore {
+	return newGCSStore(bucket, ns, client, memTableSize, nil, ReadConfig{}, WriteBackConfig{})
+}
+
+func newGCSStore(bucket, ns string, client *storage.Client, memTableSize uint64, indexCache IndexCache, readCfg ReadConfig, wbCfg WriteBackConfig) *NomsBlockStore {
+	os := gcsObjectStore{client.Bucket(bucket), ns}
+	mm := newObjectStoreManifest(os, gcsManifestName)
+	ts := newObjectStoreTableSet(os, indexCache, readCfg.orDefault())
+	return newNomsBlockStore(mm, ts, memTableSize, wbCfg)
+}
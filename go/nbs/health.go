@@ -0,0 +1,119 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"errors"
+)
+
+// TableHealth reports the result of validating a single table's footer and
+// index against its data.
+type TableHealth struct {
+	Name string
+	// Bytes is the table's on-disk/in-bucket size.
+	Bytes uint64
+	// ChunkCount is the number of chunks the table's index claims to hold.
+	ChunkCount uint32
+	// OrphanCount is the number of chunks present in the table but not
+	// referenced by any entry reachable from the manifest's chunk count
+	// bookkeeping.
+	OrphanCount uint32
+	// MismatchedHashes is the number of chunks whose recomputed hash didn't
+	// match the address under which they're indexed. Only populated when
+	// Verify is run with deep == true.
+	MismatchedHashes uint32
+	// UnreadableRanges is the number of byte ranges in the table that
+	// couldn't be read at all (e.g. S3 returned an error or a short read).
+	UnreadableRanges uint32
+}
+
+// HealthReport is the result of walking a NomsBlockStore's manifest and
+// tables to look for corruption or partial uploads before they poison a
+// read.
+type HealthReport struct {
+	Tables []TableHealth
+	// ManifestChunkCount is the sum of per-table chunk counts the manifest
+	// itself claims.
+	ManifestChunkCount uint32
+	// SummedTableChunkCount is the sum of the chunk counts actually found by
+	// reading each table's index. It should equal ManifestChunkCount; if it
+	// doesn't, the manifest and the tables it lists have drifted apart.
+	SummedTableChunkCount uint32
+}
+
+// Healthy reports whether the report found nothing wrong. It returns false
+// on a manifest/table chunk-count mismatch, or if any table has orphans,
+// hash mismatches or unreadable ranges.
+func (hr HealthReport) Healthy() bool {
+	if hr.ManifestChunkCount != hr.SummedTableChunkCount {
+		return false
+	}
+	for _, t := range hr.Tables {
+		if t.OrphanCount > 0 || t.MismatchedHashes > 0 || t.UnreadableRanges > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify walks every table listed in the current manifest, validating each
+// table's footer/index against its data. If deep is true, it also
+// recomputes every chunk's hash and compares it against the address the
+// table indexes it under; this is considerably more expensive (it reads
+// every chunk, not just the table footers/indexes) but catches bit-rot and
+// partial uploads that a shallow check would miss. Verify does not mutate
+// the store -- it's safe to run against a store that's concurrently being
+// read from and written to.
+//
+// ManifestChunkCount and SummedTableChunkCount are both derived from the
+// single tableSpecs list one ParseIfExists call returns, rather than
+// mixing the manifest's persisted state with nbs.tables' in-memory view:
+// nbs.tables can already hold tables rotated in by addChunk/Prepend before
+// the next successful UpdateRoot commits them to the manifest, which would
+// otherwise make the two counts routinely disagree on a busy, perfectly
+// healthy store.
+func (nbs *NomsBlockStore) Verify(ctx context.Context, deep bool) (HealthReport, error) {
+	exists, _, _, tableSpecs := nbs.mm.ParseIfExists(ctx, nil)
+	if !exists {
+		return HealthReport{}, nil
+	}
+
+	nbs.mu.RLock()
+	tables := nbs.tables
+	nbs.mu.RUnlock()
+
+	hts, ok := tables.(healthTableSet)
+	if !ok {
+		return HealthReport{}, errors.New("nbs: this store's tableSet does not support Verify")
+	}
+
+	var manifestChunkCount, summedChunkCount uint32
+	tableReports := make([]TableHealth, 0, len(tableSpecs))
+	for _, spec := range tableSpecs {
+		manifestChunkCount += spec.chunkCount
+
+		th, err := hts.verifyTable(ctx, spec, deep)
+		if err != nil {
+			return HealthReport{}, err
+		}
+		summedChunkCount += th.ChunkCount
+		tableReports = append(tableReports, th)
+	}
+
+	return HealthReport{
+		Tables:                tableReports,
+		ManifestChunkCount:    manifestChunkCount,
+		SummedTableChunkCount: summedChunkCount,
+	}, nil
+}
+
+// GatherHealthStats is a lightweight variant of Verify: it reads each
+// table's footer and index but never recomputes chunk hashes, making it
+// cheap enough to run as a periodic background check rather than reserving
+// Verify(ctx, true) for on-demand, deeper audits.
+func (nbs *NomsBlockStore) GatherHealthStats(ctx context.Context) (HealthReport, error) {
+	return nbs.Verify(ctx, false)
+}
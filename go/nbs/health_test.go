@@ -0,0 +1,77 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attic-labs/noms/go/hash"
+)
+
+// TestVerifyTableIsBackendAgnostic guards against Verify/GatherHealthStats
+// only working against one object-storage backend: objectStoreTableSet is
+// shared by S3 and GCS, so verifyTable must behave the same regardless of
+// which concrete objectStore backs it -- here, an in-memory one.
+func TestVerifyTableIsBackendAgnostic(t *testing.T) {
+	os := newMemObjectStore()
+	ts := newObjectStoreTableSet(os, nil, ReadConfig{})
+
+	if _, ok := interface{}(ts).(healthTableSet); !ok {
+		t.Fatalf("%T does not satisfy healthTableSet", ts)
+	}
+
+	chunk := []byte("hello")
+	a := addr(hash.Of(chunk))
+	data := encodeTable([]tableChunk{{a: a, data: chunk}})
+	name := addr(hash.Of(data))
+	if err := os.WriteObject(context.Background(), name.String(), data); err != nil {
+		t.Fatalf("WriteObject: %v", err)
+	}
+	spec := tableSpec{name: name, chunkCount: 1}
+
+	th, err := ts.verifyTable(context.Background(), spec, true)
+	if err != nil {
+		t.Fatalf("verifyTable: %v", err)
+	}
+	if th.ChunkCount != 1 || th.MismatchedHashes != 0 || th.UnreadableRanges != 0 || th.OrphanCount != 0 {
+		t.Fatalf("unexpected TableHealth: %+v", th)
+	}
+}
+
+// TestVerifyCrossChecksFromOneSnapshot pins down the fix for Verify's two
+// chunk counts drifting apart: ManifestChunkCount and SummedTableChunkCount
+// must both come from the same tableSpecs list.
+func TestVerifyCrossChecksFromOneSnapshot(t *testing.T) {
+	os := newMemObjectStore()
+	mm := newObjectStoreManifest(os, "manifest")
+	ts := newObjectStoreTableSet(os, nil, ReadConfig{})
+
+	chunk := []byte("world")
+	a := addr(hash.Of(chunk))
+	data := encodeTable([]tableChunk{{a: a, data: chunk}})
+	name := addr(hash.Of(data))
+	if err := os.WriteObject(context.Background(), name.String(), data); err != nil {
+		t.Fatalf("WriteObject: %v", err)
+	}
+	specs := []tableSpec{{name: name, chunkCount: 1}}
+
+	root := hash.Of([]byte("root"))
+	if actual, _ := mm.Update(context.Background(), specs, hash.Hash{}, root, nil); actual != root {
+		t.Fatalf("Update: expected to win the CAS, got root %s", actual)
+	}
+
+	nbs := &NomsBlockStore{mm: mm, tables: ts}
+	report, err := nbs.Verify(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.ManifestChunkCount != report.SummedTableChunkCount {
+		t.Fatalf("ManifestChunkCount (%d) != SummedTableChunkCount (%d)", report.ManifestChunkCount, report.SummedTableChunkCount)
+	}
+	if !report.Healthy() {
+		t.Fatalf("expected a healthy report, got %+v", report)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+// IndexCache abstracts the cache used to avoid re-fetching and re-parsing a
+// table's index every time it's needed. s3IndexCache (an LRU backed by an
+// in-process byte budget) is the default implementation, but tests and
+// alternate deployments may want something else -- a no-op that always
+// misses, a cache shared across many NomsBlockStore instances in the same
+// process, or one backed by an out-of-process service like groupcache or
+// memcached.
+type IndexCache interface {
+	// Get returns the tableIndex cached under name, if any.
+	Get(name addr) (idx tableIndex, ok bool)
+
+	// Set caches idx under name, evicting other entries if the
+	// implementation is space-bounded.
+	Set(name addr, idx tableIndex)
+
+	// Delete removes any tableIndex cached under name. Implementations for
+	// which that concept doesn't apply may treat this as a no-op.
+	Delete(name addr)
+}
+
+// noopIndexCache implements IndexCache but never actually caches anything,
+// so every lookup misses. Useful in tests that want to exercise the S3
+// index-fetching path itself rather than have it short-circuited.
+type noopIndexCache struct{}
+
+func (noopIndexCache) Get(name addr) (tableIndex, bool) { return tableIndex{}, false }
+func (noopIndexCache) Set(name addr, idx tableIndex)    {}
+func (noopIndexCache) Delete(name addr)                 {}
@@ -0,0 +1,78 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"encoding/binary"
+)
+
+// liveSet records the set of chunk addresses GC's mark phase found
+// reachable from the current root. It's backed by a bloom filter sized for
+// an expected chunk count, so marking a graph with many millions of chunks
+// doesn't require holding an equivalent number of addr values in memory at
+// once -- the filter's bit array is sized once, up front, from
+// expectedChunks, rather than growing with however many addresses actually
+// get added to it. A bloom filter never reports a false negative, which is
+// the only property liveSet needs: mayContain(a) == false is a guarantee a
+// is dead, while mayContain(a) == true just means a rewrite should keep a
+// rather than risk dropping a chunk that's actually still reachable.
+type liveSet struct {
+	bits      []uint64
+	numHashes int
+	count     uint64
+}
+
+// liveSetBitsPerChunk and liveSetNumHashes are tuned for roughly a 1% false-positive
+// rate, which only costs GC a slightly smaller reclaim, never correctness.
+const (
+	liveSetBitsPerChunk = 10
+	liveSetNumHashes    = 7
+)
+
+func newLiveSet(expectedChunks uint64) *liveSet {
+	numBits := expectedChunks*liveSetBitsPerChunk + 64
+	return &liveSet{
+		bits:      make([]uint64, (numBits+63)/64),
+		numHashes: liveSetNumHashes,
+	}
+}
+
+func (ls *liveSet) add(a addr) {
+	if ls.mayContain(a) {
+		return
+	}
+	for _, h := range ls.hashIndexes(a) {
+		ls.bits[h/64] |= 1 << (h % 64)
+	}
+	ls.count++
+}
+
+func (ls *liveSet) mayContain(a addr) bool {
+	for _, h := range ls.hashIndexes(a) {
+		if ls.bits[h/64]&(1<<(h%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (ls *liveSet) hashIndexes(a addr) []uint64 {
+	h1 := binary.LittleEndian.Uint64(a[:8])
+	h2 := binary.LittleEndian.Uint64(a[8:16])
+	n := uint64(len(ls.bits) * 64)
+	idxs := make([]uint64, ls.numHashes)
+	for i := range idxs {
+		idxs[i] = (h1 + uint64(i)*h2) % n
+	}
+	return idxs
+}
+
+// Count returns the number of add calls whose address the filter did not
+// already report as present. An address that collides with earlier ones (a
+// false positive) is not counted, so Count can be lower than the number of
+// distinct addresses added.
+func (ls *liveSet) Count() uint64 {
+	return ls.count
+}
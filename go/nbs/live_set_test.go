@@ -0,0 +1,42 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import "testing"
+
+func TestLiveSetNoFalseNegatives(t *testing.T) {
+	live := newLiveSet(100)
+
+	var added []addr
+	for i := 0; i < 100; i++ {
+		var a addr
+		a[0] = byte(i)
+		a[1] = byte(i >> 8)
+		live.add(a)
+		added = append(added, a)
+	}
+
+	for _, a := range added {
+		if !live.mayContain(a) {
+			t.Fatalf("bloom filter reported a false negative for %v", a)
+		}
+	}
+	if got := live.Count(); got != uint64(len(added)) {
+		t.Fatalf("Count() = %d, want %d", got, len(added))
+	}
+}
+
+func TestLiveSetAddIsIdempotent(t *testing.T) {
+	live := newLiveSet(10)
+	var a addr
+	a[0] = 1
+
+	live.add(a)
+	live.add(a)
+
+	if got := live.Count(); got != 1 {
+		t.Fatalf("Count() = %d after adding the same address twice, want 1", got)
+	}
+}
@@ -0,0 +1,798 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attic-labs/noms/go/constants"
+	"github.com/attic-labs/noms/go/d"
+	"github.com/attic-labs/noms/go/hash"
+)
+
+// objectStore is the minimal capability a blob-storage backend needs to
+// provide so that objectStoreTableSet and objectStoreManifest can implement
+// tableSet and manifest on top of it. S3 and GCS both satisfy it (S3 backed
+// by DynamoDB for the manifest's conditional update, GCS by object
+// generation preconditions), which lets the two backends share their table
+// and manifest logic instead of each reimplementing it.
+type objectStore interface {
+	// ReadObject returns the full contents of name and the generation it
+	// was read at, or ok == false if no such object exists. generation is
+	// opaque to callers other than ConditionalWriteObject -- GCS uses its
+	// native object generation, an equivalent S3 adapter could use a
+	// DynamoDB row version.
+	ReadObject(ctx context.Context, name string) (data []byte, generation int64, ok bool, err error)
+
+	// ReadObjectRange returns length bytes of name starting at offset, or
+	// ok == false if no such object exists. Used by objectStoreTableSet to
+	// issue coalesced range reads against a table's data section instead
+	// of fetching the whole object per chunk.
+	ReadObjectRange(ctx context.Context, name string, offset, length uint64) (data []byte, ok bool, err error)
+
+	// WriteObject writes data to name unconditionally, creating or
+	// overwriting it.
+	WriteObject(ctx context.Context, name string, data []byte) error
+
+	// ConditionalWriteObject writes data to name only if the object's
+	// current generation equals ifGeneration (0 meaning "must not exist
+	// yet"). It returns ok == false, with no error, on a generation
+	// mismatch so callers can re-read and retry a compare-and-swap.
+	ConditionalWriteObject(ctx context.Context, name string, data []byte, ifGeneration int64) (ok bool, err error)
+}
+
+// objectStoreManifest is a manifest implementation backed by a single
+// well-known object (name) in an objectStore, using the store's
+// conditional-write primitive to implement the manifest's compare-and-swap
+// semantics. The S3 and GCS backends both use it directly, each over its
+// own objectStore adapter (s3ObjectStore and gcsObjectStore).
+type objectStoreManifest struct {
+	os   objectStore
+	name string
+}
+
+func newObjectStoreManifest(os objectStore, name string) objectStoreManifest {
+	return objectStoreManifest{os, name}
+}
+
+func (osm objectStoreManifest) ParseIfExists(ctx context.Context, readHook func()) (exists bool, vers string, root hash.Hash, tableSpecs []tableSpec) {
+	if readHook != nil {
+		readHook()
+	}
+	data, _, ok, err := osm.os.ReadObject(ctx, osm.name)
+	d.PanicIfError(err)
+	if !ok {
+		return false, "", hash.Hash{}, nil
+	}
+	vers, root, tableSpecs = parseManifest(data)
+	return true, vers, root, tableSpecs
+}
+
+func (osm objectStoreManifest) Update(ctx context.Context, specs []tableSpec, lastRoot, newRoot hash.Hash, writeHook func()) (actual hash.Hash, tableNames []tableSpec) {
+	for {
+		data, generation, ok, err := osm.os.ReadObject(ctx, osm.name)
+		d.PanicIfError(err)
+
+		// The manifest doesn't exist yet: this is the first commit to this
+		// store, so there's no prior nomsVersion to carry forward. Fall back
+		// to constants.NomsVersion rather than persisting an empty string
+		// forever.
+		curVers := constants.NomsVersion
+		var curRoot hash.Hash
+		if ok {
+			curVers, curRoot, tableNames = parseManifest(data)
+		}
+		if ok && curRoot != lastRoot {
+			return curRoot, tableNames
+		}
+
+		if writeHook != nil {
+			writeHook()
+		}
+
+		next := formatManifest(curVers, newRoot, specs)
+		wrote, err := osm.os.ConditionalWriteObject(ctx, osm.name, next, generation)
+		d.PanicIfError(err)
+		if wrote {
+			return newRoot, specs
+		}
+		// Someone else updated the manifest concurrently; reread and retry.
+	}
+}
+
+// errManifestChanged is returned by DropAndTrash when the manifest's table
+// list has moved on from the baseSpecs a caller read earlier -- e.g.
+// because a concurrent UpdateRoot committed a new table while GC's mark
+// phase was walking the (now stale) root. A caller that sees this should
+// re-run its mark phase against the current root rather than retry the
+// swap, since the live set it computed no longer reflects reality.
+var errManifestChanged = errors.New("nbs: manifest's table list changed since caller last read it")
+
+// gcManifest is implemented by manifest types that support GC's
+// drop-and-trash sweep: atomically dropping dead tables from the manifest
+// and moving them under a trash prefix. objectStoreManifest (shared by the
+// S3 and GCS backends) is the only implementation in this tree --
+// fileManifest (the local on-disk backend, defined elsewhere) doesn't need
+// to grow this to keep satisfying plain manifest.
+type gcManifest interface {
+	// DropAndTrash swaps the manifest's table list from baseSpecs to
+	// newSpecs, failing with errManifestChanged if the manifest has moved
+	// on from baseSpecs in the meantime. For each table present in
+	// baseSpecs but not newSpecs it writes a tombstone object under
+	// trashPrefix holding the time gracePeriod from now (RFC 3339, UTC),
+	// the earliest time the table may be deleted for good. The table
+	// objects themselves are neither moved nor deleted.
+	DropAndTrash(ctx context.Context, baseSpecs, newSpecs []tableSpec, trashPrefix string, gracePeriod time.Duration) error
+}
+
+func (osm objectStoreManifest) DropAndTrash(ctx context.Context, baseSpecs, newSpecs []tableSpec, trashPrefix string, gracePeriod time.Duration) error {
+	data, generation, ok, err := osm.os.ReadObject(ctx, osm.name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errManifestChanged
+	}
+
+	curVers, curRoot, curSpecs := parseManifest(data)
+	if !specsEqual(curSpecs, baseSpecs) {
+		return errManifestChanged
+	}
+
+	next := formatManifest(curVers, curRoot, newSpecs)
+	wrote, err := osm.os.ConditionalWriteObject(ctx, osm.name, next, generation)
+	if err != nil {
+		return err
+	}
+	if !wrote {
+		return errManifestChanged
+	}
+
+	for _, spec := range diffSpecs(baseSpecs, newSpecs) {
+		tombstone := []byte(time.Now().Add(gracePeriod).UTC().Format(time.RFC3339))
+		if err := osm.os.WriteObject(ctx, trashPrefix+spec.name.String(), tombstone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func specsEqual(a, b []tableSpec) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || a[i].chunkCount != b[i].chunkCount {
+			return false
+		}
+	}
+	return true
+}
+
+// diffSpecs returns the tableSpecs present in base but not in kept.
+func diffSpecs(base, kept []tableSpec) []tableSpec {
+	keptNames := make(map[addr]bool, len(kept))
+	for _, s := range kept {
+		keptNames[s.name] = true
+	}
+	var dropped []tableSpec
+	for _, s := range base {
+		if !keptNames[s.name] {
+			dropped = append(dropped, s)
+		}
+	}
+	return dropped
+}
+
+// formatManifest and parseManifest implement the manifest's on-the-wire
+// format: a version line, a root-hash line, then one "name:chunkCount" line
+// per table. It's deliberately a plain, line-oriented format rather than a
+// binary one, since the manifest is tiny and this makes it trivial to
+// inspect by hand in a bucket browser.
+func formatManifest(vers string, root hash.Hash, specs []tableSpec) []byte {
+	var b strings.Builder
+	b.WriteString(vers)
+	b.WriteByte('\n')
+	b.WriteString(root.String())
+	b.WriteByte('\n')
+	for _, s := range specs {
+		b.WriteString(s.name.String())
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatUint(uint64(s.chunkCount), 10))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+func parseManifest(data []byte) (vers string, root hash.Hash, specs []tableSpec) {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	d.Chk.True(len(lines) >= 2, "nbs: malformed manifest, expected at least a version and root line")
+
+	vers = lines[0]
+	root, ok := hash.MaybeParse(lines[1])
+	d.Chk.True(ok, "nbs: malformed manifest root %q", lines[1])
+
+	for _, line := range lines[2:] {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		d.Chk.True(len(parts) == 2, "nbs: malformed manifest table entry %q", line)
+		nameHash, ok := hash.MaybeParse(parts[0])
+		d.Chk.True(ok, "nbs: malformed manifest table name %q", parts[0])
+		count, err := strconv.ParseUint(parts[1], 10, 32)
+		d.PanicIfError(err)
+		specs = append(specs, tableSpec{name: addr(nameHash), chunkCount: uint32(count)})
+	}
+	return vers, root, specs
+}
+
+// objectStoreTableSet is a tableSet backed by objects in an objectStore,
+// shared by the S3 and GCS backends so table-reading, index-caching and
+// read-coalescing logic isn't duplicated between them. Each open table is
+// a single object (see table_format.go); reads are parallelized and
+// coalesced per readCfg, with stats recorded into readStats so operators
+// can tune readCfg against a real workload.
+//
+// objectStoreTableSet is immutable: Prepend/Union/withoutSpecs all return a
+// new value rather than mutating the receiver, matching how
+// NomsBlockStore treats its tables field (nbs.tables = nbs.tables.X(...)).
+type objectStoreTableSet struct {
+	os         objectStore
+	indexCache IndexCache
+	readCfg    ReadConfig
+	readRl     chan struct{}
+	tableRls   *tableRateLimiters
+	stats      *readStatsRecorder
+
+	// specs holds the tables currently open, newest first -- the order in
+	// which get/has/extract(InsertOrder) should prefer them, since the
+	// newest table is the most likely to hold a recently-written chunk.
+	specs []tableSpec
+}
+
+// tableRateLimiters hands out a per-table semaphore, created lazily and
+// shared across every objectStoreTableSet value derived from the same
+// newObjectStoreTableSet call (Prepend/Union/withoutSpecs all copy the
+// struct but keep the same *tableRateLimiters), so PerTableConcurrency is
+// enforced per table name rather than once per tableSet value.
+type tableRateLimiters struct {
+	mu  sync.Mutex
+	cap int
+	sem map[addr]chan struct{}
+}
+
+func (trl *tableRateLimiters) get(name addr) chan struct{} {
+	trl.mu.Lock()
+	defer trl.mu.Unlock()
+	sem, ok := trl.sem[name]
+	if !ok {
+		sem = make(chan struct{}, trl.cap)
+		trl.sem[name] = sem
+	}
+	return sem
+}
+
+func newObjectStoreTableSet(os objectStore, indexCache IndexCache, readCfg ReadConfig) objectStoreTableSet {
+	readCfg = readCfg.orDefault()
+	if indexCache == nil {
+		indexCache = noopIndexCache{}
+	}
+	return objectStoreTableSet{
+		os:         os,
+		indexCache: indexCache,
+		readCfg:    readCfg,
+		readRl:     make(chan struct{}, readCfg.Parallelism),
+		tableRls:   &tableRateLimiters{cap: readCfg.PerTableConcurrency, sem: map[addr]chan struct{}{}},
+		stats:      &readStatsRecorder{},
+	}
+}
+
+func (ts objectStoreTableSet) readStats() ReadStats {
+	return ts.stats.snapshot()
+}
+
+// index returns spec's parsed tableIndex, consulting and populating
+// ts.indexCache so a hot table's index is only fetched and decoded once.
+func (ts objectStoreTableSet) index(ctx context.Context, spec tableSpec) (tableIndex, error) {
+	if idx, ok := ts.indexCache.Get(spec.name); ok {
+		return idx, nil
+	}
+	data, _, ok, err := ts.os.ReadObject(ctx, spec.name.String())
+	if err != nil {
+		return tableIndex{}, err
+	}
+	if !ok {
+		return tableIndex{}, fmt.Errorf("nbs: table %s is missing from the object store", spec.name.String())
+	}
+	idx, err := decodeTableIndex(data)
+	if err != nil {
+		return tableIndex{}, err
+	}
+	ts.indexCache.Set(spec.name, idx)
+	return idx, nil
+}
+
+func (ts objectStoreTableSet) get(ctx context.Context, a addr) []byte {
+	for _, spec := range ts.specs {
+		idx, err := ts.index(ctx, spec)
+		if err != nil {
+			continue
+		}
+		entry, ok := idx.entries[a]
+		if !ok {
+			continue
+		}
+		data, ok, err := ts.os.ReadObjectRange(ctx, spec.name.String(), entry.offset, uint64(entry.length))
+		if err != nil || !ok {
+			continue
+		}
+		return data
+	}
+	return nil
+}
+
+func (ts objectStoreTableSet) has(ctx context.Context, a addr) bool {
+	for _, spec := range ts.specs {
+		idx, err := ts.index(ctx, spec)
+		if err != nil {
+			continue
+		}
+		if _, ok := idx.entries[a]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (ts objectStoreTableSet) count(ctx context.Context) uint32 {
+	var n uint32
+	for _, spec := range ts.specs {
+		n += spec.chunkCount
+	}
+	return n
+}
+
+func (ts objectStoreTableSet) extract(ctx context.Context, order EnumerationOrder, ch chan<- extractRecord) {
+	ordered := make([]tableSpec, len(ts.specs))
+	copy(ordered, ts.specs)
+	// ts.specs is newest-first; InsertOrder wants oldest first.
+	if order == InsertOrder {
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	}
+
+	for _, spec := range ordered {
+		idx, err := ts.index(ctx, spec)
+		if err != nil {
+			continue
+		}
+		names := idx.order
+		if order == ReverseOrder {
+			for i := len(names) - 1; i >= 0; i-- {
+				if !ts.extractOne(ctx, spec, idx, names[i], ch) {
+					return
+				}
+			}
+		} else {
+			for _, a := range names {
+				if !ts.extractOne(ctx, spec, idx, a, ch) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (ts objectStoreTableSet) extractOne(ctx context.Context, spec tableSpec, idx tableIndex, a addr, ch chan<- extractRecord) bool {
+	e := idx.entries[a]
+	data, ok, err := ts.os.ReadObjectRange(ctx, spec.name.String(), e.offset, uint64(e.length))
+	if err != nil || !ok {
+		return true
+	}
+	select {
+	case ch <- extractRecord{a: a, data: data}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// calcReads simulates the coalesced range reads getMany would issue for
+// reqs (already sorted by prefix), without actually fetching anything --
+// used by NomsBlockStore.CalcReads to let callers estimate a query's cost.
+func (ts objectStoreTableSet) calcReads(reqs []getRecord, blockSize, maxReadSize, ampThresh uint64) (reads int, split bool, remaining bool) {
+	pending := make([]int, len(reqs))
+	for i := range reqs {
+		pending[i] = i
+	}
+
+	for _, spec := range ts.specs {
+		if len(pending) == 0 {
+			break
+		}
+		idx, err := ts.index(context.Background(), spec)
+		if err != nil {
+			continue
+		}
+
+		var hits, misses []int
+		for _, i := range pending {
+			if _, ok := idx.entries[*reqs[i].a]; ok {
+				hits = append(hits, i)
+			} else {
+				misses = append(misses, i)
+			}
+		}
+		pending = misses
+		if len(hits) == 0 {
+			continue
+		}
+
+		cfg := ReadConfig{BlockSize: blockSize, MaxCoalescedRangeBytes: maxReadSize, AmpThreshold: ampThresh}
+		for _, g := range coalesceHits(idx, reqs, hits, cfg) {
+			reads++
+			if g.amplified {
+				split = true
+			}
+		}
+	}
+	return reads, split, len(pending) > 0
+}
+
+// coalescedRead is one merged range read: the [start, end) byte range to
+// fetch from a table's data section, and the requests it satisfies.
+type coalescedRead struct {
+	start, end uint64
+	items      []coalescedItem
+	amplified  bool // true if this group was ended because merging the next request would have exceeded AmpThreshold's ratio
+}
+
+type coalescedItem struct {
+	reqIndex int // index into the reqs slice passed to getMany/calcReads
+	offset   uint64
+	length   uint32
+}
+
+// coalesceHits groups hits (indexes into reqs, all known present in idx)
+// into range reads: requests whose table offsets are within cfg.BlockSize
+// of each other are merged into a single range GET, so long as the merged
+// range stays within cfg.MaxCoalescedRangeBytes and its amplification
+// (range bytes / requested bytes) doesn't exceed cfg.AmpThreshold.
+func coalesceHits(idx tableIndex, reqs []getRecord, hits []int, cfg ReadConfig) []coalescedRead {
+	sort.Slice(hits, func(i, j int) bool {
+		return idx.entries[*reqs[hits[i]].a].offset < idx.entries[*reqs[hits[j]].a].offset
+	})
+
+	first := idx.entries[*reqs[hits[0]].a]
+	cur := coalescedRead{
+		start: first.offset,
+		end:   first.offset + uint64(first.length),
+		items: []coalescedItem{{hits[0], 0, first.length}},
+	}
+
+	var groups []coalescedRead
+	for _, i := range hits[1:] {
+		e := idx.entries[*reqs[i].a]
+		gap := e.offset - cur.end
+		newEnd := e.offset + uint64(e.length)
+		requested := uint64(e.length)
+		for _, it := range cur.items {
+			requested += uint64(it.length)
+		}
+
+		if e.offset >= cur.end && gap <= cfg.BlockSize && newEnd-cur.start <= cfg.MaxCoalescedRangeBytes {
+			amplified := float64(newEnd-cur.start) > float64(requested)*float64(cfg.AmpThreshold)
+			if !amplified {
+				cur.items = append(cur.items, coalescedItem{i, e.offset - cur.start, e.length})
+				cur.end = newEnd
+				continue
+			}
+			cur.amplified = true
+		}
+		groups = append(groups, cur)
+		cur = coalescedRead{start: e.offset, end: newEnd, items: []coalescedItem{{i, 0, e.length}}}
+	}
+	groups = append(groups, cur)
+	return groups
+}
+
+// getMany resolves reqs against ts.specs, grouping same-table requests into
+// coalesced range reads per ts.readCfg and fanning them out through
+// ts.readRl/ts.tableRls. Resolved requests have their data field set
+// directly (reqs is shared with the caller, same as memTable.getMany).
+func (ts objectStoreTableSet) getMany(ctx context.Context, reqs []getRecord, wg *sync.WaitGroup) (remaining bool) {
+	pending := make([]int, 0, len(reqs))
+	for i, r := range reqs {
+		if r.data == nil {
+			pending = append(pending, i)
+		}
+	}
+
+	for _, spec := range ts.specs {
+		if len(pending) == 0 {
+			break
+		}
+		idx, err := ts.index(ctx, spec)
+		if err != nil {
+			continue
+		}
+
+		var hits, misses []int
+		for _, i := range pending {
+			if _, ok := idx.entries[*reqs[i].a]; ok {
+				hits = append(hits, i)
+			} else {
+				misses = append(misses, i)
+			}
+		}
+		pending = misses
+		if len(hits) == 0 {
+			continue
+		}
+
+		for _, g := range coalesceHits(idx, reqs, hits, ts.readCfg) {
+			g := g
+			spec := spec
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ts.fetchRange(ctx, spec, reqs, g)
+			}()
+		}
+	}
+	return len(pending) > 0
+}
+
+func (ts objectStoreTableSet) fetchRange(ctx context.Context, spec tableSpec, reqs []getRecord, g coalescedRead) {
+	if !ts.acquire(ctx, ts.readRl) {
+		return
+	}
+	defer ts.release(ts.readRl)
+	tableRl := ts.tableRls.get(spec.name)
+	if !ts.acquire(ctx, tableRl) {
+		return
+	}
+	defer ts.release(tableRl)
+
+	var requested uint64
+	for _, it := range g.items {
+		requested += uint64(it.length)
+	}
+	ts.stats.recordRequested(requested)
+
+	data, ok, err := ts.os.ReadObjectRange(ctx, spec.name.String(), g.start, g.end-g.start)
+	if err != nil || !ok {
+		return
+	}
+	ts.stats.recordFetched(uint64(len(data)))
+
+	for _, it := range g.items {
+		if it.offset+uint64(it.length) > uint64(len(data)) {
+			continue
+		}
+		reqs[it.reqIndex].data = data[it.offset : it.offset+uint64(it.length)]
+	}
+}
+
+func (ts objectStoreTableSet) acquire(ctx context.Context, sem chan struct{}) bool {
+	start := time.Now()
+	select {
+	case sem <- struct{}{}:
+		ts.stats.recordSemaphoreWait(time.Since(start).Nanoseconds())
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (ts objectStoreTableSet) release(sem chan struct{}) {
+	<-sem
+}
+
+func (ts objectStoreTableSet) Prepend(ctx context.Context, mt *memTable) tableSet {
+	ch := make(chan extractRecord, 128)
+	go func() {
+		mt.extract(InsertOrder, ch)
+		close(ch)
+	}()
+
+	var chunx []tableChunk
+	for rec := range ch {
+		chunx = append(chunx, tableChunk{a: rec.a, data: rec.data})
+	}
+	if len(chunx) == 0 {
+		return ts
+	}
+
+	data := encodeTable(chunx)
+	name := addr(hash.Of(data))
+	d.PanicIfError(ts.os.WriteObject(ctx, name.String(), data))
+
+	next := ts
+	next.specs = append([]tableSpec{{name: name, chunkCount: uint32(len(chunx))}}, ts.specs...)
+	return next
+}
+
+func (ts objectStoreTableSet) Union(specs []tableSpec) tableSet {
+	existing := make(map[addr]bool, len(ts.specs))
+	for _, s := range ts.specs {
+		existing[s.name] = true
+	}
+
+	merged := make([]tableSpec, len(ts.specs), len(ts.specs)+len(specs))
+	copy(merged, ts.specs)
+	for _, s := range specs {
+		if !existing[s.name] {
+			merged = append(merged, s)
+			existing[s.name] = true
+		}
+	}
+
+	next := ts
+	next.specs = merged
+	return next
+}
+
+func (ts objectStoreTableSet) ToSpecs() []tableSpec {
+	out := make([]tableSpec, len(ts.specs))
+	copy(out, ts.specs)
+	return out
+}
+
+func (ts objectStoreTableSet) Close(ctx context.Context) error {
+	return nil
+}
+
+// withoutSpecs returns ts with the named tables evicted from its open set.
+// Used after a successful GC sweep to drop tables that were just trashed,
+// without disturbing any table a concurrent writer may have added since
+// the sweep's snapshot was taken.
+func (ts objectStoreTableSet) withoutSpecs(drop []tableSpec) tableSet {
+	dropNames := make(map[addr]bool, len(drop))
+	for _, s := range drop {
+		dropNames[s.name] = true
+	}
+
+	next := ts
+	next.specs = nil
+	for _, s := range ts.specs {
+		if dropNames[s.name] {
+			ts.indexCache.Delete(s.name)
+			continue
+		}
+		next.specs = append(next.specs, s)
+	}
+	return next
+}
+
+// gcTableSet is implemented by tableSet types that support GC's sweep:
+// scoring a single table's dead chunks against a liveSet and rewriting it
+// down to just the live ones. objectStoreTableSet (shared by the S3 and
+// GCS backends) is the only implementation in this tree -- fsTableSet (the
+// local on-disk backend, defined elsewhere) doesn't need to grow these to
+// keep satisfying plain tableSet.
+type gcTableSet interface {
+	// deadRatio reports the fraction, by chunk count, of spec's chunks that
+	// aren't present in live, along with spec's approximate size in bytes.
+	deadRatio(ctx context.Context, spec tableSpec, live *liveSet) (ratio float64, tableBytes uint64, err error)
+	// rewriteLive writes a new table holding only spec's chunks that are
+	// present in live, returning its tableSpec. It returns the zero
+	// tableSpec if every chunk in spec turned out to be dead.
+	rewriteLive(ctx context.Context, spec tableSpec, live *liveSet) (newSpec tableSpec, err error)
+	// withoutSpecs returns a tableSet with the named tables evicted from
+	// its open set.
+	withoutSpecs(drop []tableSpec) tableSet
+}
+
+func (ts objectStoreTableSet) deadRatio(ctx context.Context, spec tableSpec, live *liveSet) (ratio float64, tableBytes uint64, err error) {
+	idx, err := ts.index(ctx, spec)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(idx.order) == 0 {
+		return 0, 0, nil
+	}
+
+	var deadCount int
+	var dataBytes uint64
+	for _, a := range idx.order {
+		if !live.mayContain(a) {
+			deadCount++
+		}
+		dataBytes += uint64(idx.entries[a].length)
+	}
+	tableBytes = dataBytes + uint64(len(idx.order))*tableIndexEntrySize + tableFooterSize
+
+	return float64(deadCount) / float64(len(idx.order)), tableBytes, nil
+}
+
+func (ts objectStoreTableSet) rewriteLive(ctx context.Context, spec tableSpec, live *liveSet) (tableSpec, error) {
+	idx, err := ts.index(ctx, spec)
+	if err != nil {
+		return tableSpec{}, err
+	}
+
+	data, _, ok, err := ts.os.ReadObject(ctx, spec.name.String())
+	if err != nil {
+		return tableSpec{}, err
+	}
+	if !ok {
+		return tableSpec{}, fmt.Errorf("nbs: table %s is missing from the object store", spec.name.String())
+	}
+
+	var chunx []tableChunk
+	for _, a := range idx.order {
+		if !live.mayContain(a) {
+			continue
+		}
+		e := idx.entries[a]
+		chunx = append(chunx, tableChunk{a: a, data: data[e.offset : e.offset+uint64(e.length)]})
+	}
+	if len(chunx) == 0 {
+		return tableSpec{}, nil
+	}
+
+	out := encodeTable(chunx)
+	name := addr(hash.Of(out))
+	if err := ts.os.WriteObject(ctx, name.String(), out); err != nil {
+		return tableSpec{}, err
+	}
+
+	return tableSpec{name: name, chunkCount: uint32(len(chunx))}, nil
+}
+
+// healthTableSet is implemented by tableSet types that support Verify:
+// validating a single table's footer/index against its data, and
+// optionally (deep) against every chunk's recomputed hash.
+// objectStoreTableSet (shared by the S3 and GCS backends) is the only
+// implementation in this tree.
+type healthTableSet interface {
+	verifyTable(ctx context.Context, spec tableSpec, deep bool) (TableHealth, error)
+}
+
+func (ts objectStoreTableSet) verifyTable(ctx context.Context, spec tableSpec, deep bool) (TableHealth, error) {
+	th := TableHealth{Name: spec.name.String()}
+
+	data, _, ok, err := ts.os.ReadObject(ctx, spec.name.String())
+	if err != nil || !ok {
+		th.UnreadableRanges = 1
+		return th, nil
+	}
+	th.Bytes = uint64(len(data))
+
+	idx, err := decodeTableIndex(data)
+	if err != nil {
+		th.UnreadableRanges = 1
+		return th, nil
+	}
+	th.ChunkCount = idx.chunkCount()
+	if idx.chunkCount() > spec.chunkCount {
+		th.OrphanCount = idx.chunkCount() - spec.chunkCount
+	}
+
+	if deep {
+		for _, a := range idx.order {
+			e := idx.entries[a]
+			if e.offset+uint64(e.length) > uint64(len(data)) {
+				th.UnreadableRanges++
+				continue
+			}
+			if addr(hash.Of(data[e.offset:e.offset+uint64(e.length)])) != a {
+				th.MismatchedHashes++
+			}
+		}
+	}
+
+	return th, nil
+}
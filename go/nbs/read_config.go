@@ -0,0 +1,125 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import "sync/atomic"
+
+// ReadConfig tunes how the read path parallelizes and coalesces requests
+// against the underlying table storage. It's the GetMany-time analog of the
+// blockSize/maxReadSize/ampThresh parameters CalcReads already accepts --
+// objectStoreTableSet uses the same logic (coalesceHits) for both to decide
+// whether to merge neighboring chunk reads into a single range GET.
+type ReadConfig struct {
+	// Parallelism bounds how many range reads may be in flight against the
+	// backend at once, across all tables. Replaces the fixed
+	// defaultAWSReadLimit-sized semaphore every AWSStoreFactory used to
+	// share regardless of workload.
+	Parallelism int
+	// PerTableConcurrency further bounds how many of those in-flight reads
+	// may target the same table at once, so one hot table can't starve
+	// reads against the rest of the store.
+	PerTableConcurrency int
+	// BlockSize is the read granularity CalcReads-style coalescing assumes;
+	// gaps no larger than BlockSize between two requested chunks are merged
+	// into a single range GET covering both.
+	BlockSize uint64
+	// MaxCoalescedRangeBytes caps how large a merged range GET may grow
+	// before it's split back into separate requests -- coalescing beyond
+	// this point would fetch more wasted bytes than the extra round trip
+	// it saves.
+	MaxCoalescedRangeBytes uint64
+	// AmpThreshold is the maximum acceptable read amplification (bytes
+	// fetched / bytes requested) for a coalesced range before it's worth
+	// splitting, mirroring CalcReads' ampThresh parameter.
+	AmpThreshold uint64
+}
+
+var defaultReadConfig = ReadConfig{
+	Parallelism:            defaultAWSReadLimit,
+	PerTableConcurrency:    64,
+	BlockSize:              1 << 12,        // 4KB
+	MaxCoalescedRangeBytes: 16 * (1 << 20), // 16MB
+	AmpThreshold:           2,
+}
+
+func (cfg ReadConfig) orDefault() ReadConfig {
+	if cfg.Parallelism == 0 {
+		cfg.Parallelism = defaultReadConfig.Parallelism
+	}
+	if cfg.PerTableConcurrency == 0 {
+		cfg.PerTableConcurrency = defaultReadConfig.PerTableConcurrency
+	}
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = defaultReadConfig.BlockSize
+	}
+	if cfg.MaxCoalescedRangeBytes == 0 {
+		cfg.MaxCoalescedRangeBytes = defaultReadConfig.MaxCoalescedRangeBytes
+	}
+	if cfg.AmpThreshold == 0 {
+		cfg.AmpThreshold = defaultReadConfig.AmpThreshold
+	}
+	return cfg
+}
+
+// ReadStats exposes Prometheus-style counters for the read path, so
+// operators can tune ReadConfig's knobs against a real workload instead of
+// guessing. A histogram of coalesced-range sizes is tracked as a handful of
+// power-of-two buckets rather than a full distribution, matching the
+// granularity operators actually act on.
+type ReadStats struct {
+	// BytesRequested is the sum of chunk sizes callers asked for.
+	BytesRequested uint64
+	// BytesFetched is the sum of bytes actually pulled over the wire,
+	// including padding from coalescing neighboring chunks into one range
+	// GET. BytesFetched / BytesRequested is the read path's amplification.
+	BytesFetched uint64
+	// CoalescedRangeHistogram buckets coalesced range-GET sizes by
+	// log2(bytes), e.g. CoalescedRangeHistogram[20] counts ranges between
+	// 1MB and 2MB.
+	CoalescedRangeHistogram [64]uint64
+	// SemaphoreWaitNanos is the total time callers spent blocked acquiring
+	// a Parallelism or PerTableConcurrency slot.
+	SemaphoreWaitNanos uint64
+}
+
+// readStatsRecorder accumulates ReadStats via atomic adds so it can be
+// shared across the goroutines a coalesced GetMany fans out to.
+type readStatsRecorder struct {
+	bytesRequested     uint64
+	bytesFetched       uint64
+	histogram          [64]uint64
+	semaphoreWaitNanos uint64
+}
+
+func (r *readStatsRecorder) recordRequested(bytes uint64) {
+	atomic.AddUint64(&r.bytesRequested, bytes)
+}
+
+func (r *readStatsRecorder) recordFetched(rangeBytes uint64) {
+	atomic.AddUint64(&r.bytesFetched, rangeBytes)
+	bucket := 0
+	for n := rangeBytes; n > 1; n >>= 1 {
+		bucket++
+	}
+	if bucket < len(r.histogram) {
+		atomic.AddUint64(&r.histogram[bucket], 1)
+	}
+}
+
+func (r *readStatsRecorder) recordSemaphoreWait(nanos int64) {
+	atomic.AddUint64(&r.semaphoreWaitNanos, uint64(nanos))
+}
+
+func (r *readStatsRecorder) snapshot() ReadStats {
+	stats := ReadStats{
+		BytesRequested:     atomic.LoadUint64(&r.bytesRequested),
+		BytesFetched:       atomic.LoadUint64(&r.bytesFetched),
+		SemaphoreWaitNanos: atomic.LoadUint64(&r.semaphoreWaitNanos),
+	}
+	for i := range r.histogram {
+		stats.CoalescedRangeHistogram[i] = atomic.LoadUint64(&r.histogram[i])
+	}
+	return stats
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// awsManifestName is the well-known object holding the manifest, mirroring
+// gcsManifestName.
+const awsManifestName = "manifest"
+
+// s3ObjectStore adapts an *s3.S3 bucket and a DynamoDB table to the
+// objectStore interface, the S3/DynamoDB equivalent of gcsObjectStore's
+// GCS-native implementation. Table data always lives in S3; S3 itself has
+// no notion of a per-object generation the way GCS does, so
+// ConditionalWriteObject tracks one in a DynamoDB row per object key --
+// the same role a conditional PutItem played for the manifest alone
+// before objectStoreTableSet/objectStoreManifest were factored out to be
+// shared with gcsObjectStore/GCS.
+type s3ObjectStore struct {
+	s3     *s3.S3
+	ddb    *dynamodb.DynamoDB
+	bucket string
+	table  string // DynamoDB table tracking object generations
+	ns     string
+}
+
+func newS3ObjectStore(s3svc *s3.S3, ddb *dynamodb.DynamoDB, bucket, table, ns string) s3ObjectStore {
+	return s3ObjectStore{s3svc, ddb, bucket, table, ns}
+}
+
+func (s s3ObjectStore) key(name string) string {
+	if s.ns == "" {
+		return name
+	}
+	return s.ns + "/" + name
+}
+
+func (s s3ObjectStore) ReadObject(ctx context.Context, name string) (data []byte, generation int64, ok bool, err error) {
+	out, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if isNoSuchKey(err) {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer out.Body.Close()
+	if data, err = ioutil.ReadAll(out.Body); err != nil {
+		return nil, 0, false, err
+	}
+	if generation, err = s.readGeneration(ctx, name); err != nil {
+		return nil, 0, false, err
+	}
+	return data, generation, true, nil
+}
+
+// ReadObjectRange reads a byte range out of name using an S3 ranged GET, so
+// objectStoreTableSet's coalesced reads only pull the bytes a table's index
+// says they need rather than the whole object.
+func (s s3ObjectStore) ReadObjectRange(ctx context.Context, name string, offset, length uint64) (data []byte, ok bool, err error) {
+	out, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if isNoSuchKey(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer out.Body.Close()
+	data, err = ioutil.ReadAll(out.Body)
+	return data, err == nil, err
+}
+
+func (s s3ObjectStore) WriteObject(ctx context.Context, name string, data []byte) error {
+	_, err := s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// ConditionalWriteObject writes data to name iff name's DynamoDB-tracked
+// generation is ifGeneration, claiming the new generation with a
+// conditional PutItem before writing the object itself. A lost race
+// surfaces from DynamoDB as a ConditionalCheckFailedException, which we
+// translate into ok == false rather than an error, mirroring
+// gcsObjectStore's handling of a 412 Precondition Failed.
+func (s s3ObjectStore) ConditionalWriteObject(ctx context.Context, name string, data []byte, ifGeneration int64) (ok bool, err error) {
+	cond, args := conditionExpression(ifGeneration)
+
+	_, err = s.ddb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":        {S: aws.String(s.key(name))},
+			"generation": {N: aws.String(strconv.FormatInt(ifGeneration+1, 10))},
+		},
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeValues: args,
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err = s.WriteObject(ctx, name, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// conditionExpression builds the DynamoDB condition that claims the next
+// generation after ifGeneration: that no row exists yet if ifGeneration ==
+// 0 (the object doesn't exist yet), or that the row's generation still
+// matches ifGeneration otherwise.
+func conditionExpression(ifGeneration int64) (string, map[string]*dynamodb.AttributeValue) {
+	if ifGeneration == 0 {
+		return "attribute_not_exists(generation)", nil
+	}
+	return "generation = :gen", map[string]*dynamodb.AttributeValue{
+		":gen": {N: aws.String(strconv.FormatInt(ifGeneration, 10))},
+	}
+}
+
+func (s s3ObjectStore) readGeneration(ctx context.Context, name string) (int64, error) {
+	out, err := s.ddb.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]*dynamodb.AttributeValue{"key": {S: aws.String(s.key(name))}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if out.Item == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(aws.StringValue(out.Item["generation"].N), 10, 64)
+}
+
+func isNoSuchKey(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchKey
+}
+
+func isConditionalCheckFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestConditionExpressionFirstWrite(t *testing.T) {
+	cond, args := conditionExpression(0)
+	if cond != "attribute_not_exists(generation)" {
+		t.Fatalf("expected an existence check for generation 0, got %q", cond)
+	}
+	if args != nil {
+		t.Fatalf("expected no expression attribute values for generation 0, got %v", args)
+	}
+}
+
+func TestConditionExpressionSubsequentWrite(t *testing.T) {
+	cond, args := conditionExpression(3)
+	if cond != "generation = :gen" {
+		t.Fatalf("expected an equality check against the prior generation, got %q", cond)
+	}
+	if got := aws.StringValue(args[":gen"].N); got != "3" {
+		t.Fatalf("expected :gen to bind the prior generation 3, got %q", got)
+	}
+}
+
+func TestS3ObjectStoreKeyNamespacing(t *testing.T) {
+	nsed := s3ObjectStore{ns: "myns"}
+	if got := nsed.key("manifest"); got != "myns/manifest" {
+		t.Fatalf("expected namespaced key, got %q", got)
+	}
+
+	unnsed := s3ObjectStore{}
+	if got := unnsed.key("manifest"); got != "manifest" {
+		t.Fatalf("expected unnamespaced key to pass through unchanged, got %q", got)
+	}
+}
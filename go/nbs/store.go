@@ -5,6 +5,7 @@
 package nbs
 
 import (
+	"context"
 	"sort"
 	"sync"
 
@@ -46,45 +47,84 @@ type NomsBlockStore struct {
 	root   hash.Hash
 
 	putCount uint64
+
+	wb *writeBackPipeline
 }
 
 type AWSStoreFactory struct {
 	sess          *session.Session
 	table, bucket string
-	indexCache    *s3IndexCache
-	readRl        chan struct{}
+	indexCache    IndexCache
+	readCfg       ReadConfig
+	wbCfg         WriteBackConfig
+}
+
+// AWSStoreFactoryOption configures an AWSStoreFactory at construction time.
+type AWSStoreFactoryOption func(*AWSStoreFactory)
+
+// WithIndexCache overrides the IndexCache that CreateStore-produced stores
+// share. By default, NewAWSStoreFactory wires up an s3IndexCache bounded by
+// indexCacheSize; passing this option replaces it entirely, e.g. with a
+// noopIndexCache in tests or a cache shared across factories in a process.
+func WithIndexCache(indexCache IndexCache) AWSStoreFactoryOption {
+	return func(asf *AWSStoreFactory) {
+		asf.indexCache = indexCache
+	}
+}
+
+// WithWriteBackConfig overrides the concurrency/buffering of the background
+// write-back pipeline backing SchedulePut for stores this factory creates.
+func WithWriteBackConfig(cfg WriteBackConfig) AWSStoreFactoryOption {
+	return func(asf *AWSStoreFactory) {
+		asf.wbCfg = cfg
+	}
+}
+
+// WithReadConfig overrides the read path's parallelism and coalescing
+// knobs for stores this factory creates. By default, a factory uses
+// defaultReadConfig, which reproduces the previous fixed
+// defaultAWSReadLimit-sized semaphore with no coalescing tuning.
+func WithReadConfig(cfg ReadConfig) AWSStoreFactoryOption {
+	return func(asf *AWSStoreFactory) {
+		asf.readCfg = cfg
+	}
 }
 
-func NewAWSStoreFactory(sess *session.Session, table, bucket string, indexCacheSize uint64) chunks.Factory {
-	var indexCache *s3IndexCache
+func NewAWSStoreFactory(sess *session.Session, table, bucket string, indexCacheSize uint64, opts ...AWSStoreFactoryOption) chunks.Factory {
+	var indexCache IndexCache
 	if indexCacheSize > 0 {
 		indexCache = newS3IndexCache(indexCacheSize)
 	}
-	return &AWSStoreFactory{sess, table, bucket, indexCache, make(chan struct{}, defaultAWSReadLimit)}
+	asf := &AWSStoreFactory{sess, table, bucket, indexCache, ReadConfig{}, WriteBackConfig{}}
+	for _, opt := range opts {
+		opt(asf)
+	}
+	return asf
 }
 
 func (asf *AWSStoreFactory) CreateStore(ns string) chunks.ChunkStore {
-	return newAWSStore(asf.table, ns, asf.bucket, asf.sess, defaultMemTableSize, asf.indexCache, asf.readRl)
+	return newAWSStore(asf.table, ns, asf.bucket, asf.sess, defaultMemTableSize, asf.indexCache, asf.readCfg, asf.wbCfg)
 }
 
 func (asf *AWSStoreFactory) Shutter() {
 }
 
 func NewAWSStore(table, ns, bucket string, sess *session.Session, memTableSize uint64) *NomsBlockStore {
-	return newAWSStore(table, ns, bucket, sess, memTableSize, nil, nil)
+	return newAWSStore(table, ns, bucket, sess, memTableSize, nil, ReadConfig{}, WriteBackConfig{})
 }
 
-func newAWSStore(table, ns, bucket string, sess *session.Session, memTableSize uint64, indexCache *s3IndexCache, readRl chan struct{}) *NomsBlockStore {
-	mm := newDynamoManifest(table, ns, dynamodb.New(sess))
-	ts := newS3TableSet(s3.New(sess), bucket, indexCache, readRl)
-	return newNomsBlockStore(mm, ts, memTableSize)
+func newAWSStore(table, ns, bucket string, sess *session.Session, memTableSize uint64, indexCache IndexCache, readCfg ReadConfig, wbCfg WriteBackConfig) *NomsBlockStore {
+	os := newS3ObjectStore(s3.New(sess), dynamodb.New(sess), bucket, table, ns)
+	mm := newObjectStoreManifest(os, awsManifestName)
+	ts := newObjectStoreTableSet(os, indexCache, readCfg.orDefault())
+	return newNomsBlockStore(mm, ts, memTableSize, wbCfg)
 }
 
 func NewLocalStore(dir string, memTableSize uint64) *NomsBlockStore {
-	return newNomsBlockStore(fileManifest{dir}, newFSTableSet(dir), memTableSize)
+	return newNomsBlockStore(fileManifest{dir}, newFSTableSet(dir), memTableSize, WriteBackConfig{})
 }
 
-func newNomsBlockStore(mm manifest, ts tableSet, memTableSize uint64) *NomsBlockStore {
+func newNomsBlockStore(mm manifest, ts tableSet, memTableSize uint64, wbCfg WriteBackConfig) *NomsBlockStore {
 	if memTableSize == 0 {
 		memTableSize = defaultMemTableSize
 	}
@@ -94,8 +134,9 @@ func newNomsBlockStore(mm manifest, ts tableSet, memTableSize uint64) *NomsBlock
 		nomsVersion: constants.NomsVersion,
 		mtSize:      memTableSize,
 	}
+	nbs.wb = newWriteBackPipeline(wbCfg, nbs.addChunk)
 
-	if exists, vers, root, tableSpecs := nbs.mm.ParseIfExists(nil); exists {
+	if exists, vers, root, tableSpecs := nbs.mm.ParseIfExists(context.Background(), nil); exists {
 		nbs.nomsVersion, nbs.root = vers, root
 		nbs.tables = nbs.tables.Union(tableSpecs)
 	}
@@ -103,21 +144,27 @@ func newNomsBlockStore(mm manifest, ts tableSet, memTableSize uint64) *NomsBlock
 	return nbs
 }
 
-func (nbs *NomsBlockStore) Put(c chunks.Chunk) {
+func (nbs *NomsBlockStore) Put(ctx context.Context, c chunks.Chunk) {
 	a := addr(c.Hash())
-	d.PanicIfFalse(nbs.addChunk(a, c.Data()))
+	d.PanicIfFalse(nbs.addChunk(ctx, a, c.Data()))
 	nbs.putCount++
 }
 
+// SchedulePut enqueues c for asynchronous write-back rather than blocking
+// the caller on a full Put. Get and Has consult the pending queue so
+// read-your-writes holds even before c has been applied; callers that need
+// a stronger guarantee can await completion in bulk via WaitForWriteBack or
+// Flush. If the pipeline's in-flight byte budget is exceeded, SchedulePut
+// blocks until there's room rather than letting memory grow without bound.
 func (nbs *NomsBlockStore) SchedulePut(c chunks.Chunk, refHeight uint64, hints types.Hints) {
-	nbs.Put(c)
+	nbs.wb.schedulePut(context.Background(), addr(c.Hash()), c.Data())
 }
 
-func (nbs *NomsBlockStore) PutMany(chunx []chunks.Chunk) (err chunks.BackpressureError) {
+func (nbs *NomsBlockStore) PutMany(ctx context.Context, chunx []chunks.Chunk) (err chunks.BackpressureError) {
 	for ; len(chunx) > 0; chunx = chunx[1:] {
 		c := chunx[0]
 		a := addr(c.Hash())
-		if !nbs.addChunk(a, c.Data()) {
+		if !nbs.addChunk(ctx, a, c.Data()) {
 			break
 		}
 		nbs.putCount++
@@ -130,22 +177,25 @@ func (nbs *NomsBlockStore) PutMany(chunx []chunks.Chunk) (err chunks.Backpressur
 }
 
 // TODO: figure out if there's a non-error reason for this to return false. If not, get rid of return value.
-func (nbs *NomsBlockStore) addChunk(h addr, data []byte) bool {
+func (nbs *NomsBlockStore) addChunk(ctx context.Context, h addr, data []byte) bool {
 	nbs.mu.Lock()
 	defer nbs.mu.Unlock()
 	if nbs.mt == nil {
 		nbs.mt = newMemTable(nbs.mtSize)
 	}
 	if !nbs.mt.addChunk(h, data) {
-		nbs.tables = nbs.tables.Prepend(nbs.mt)
+		nbs.tables = nbs.tables.Prepend(ctx, nbs.mt)
 		nbs.mt = newMemTable(nbs.mtSize)
 		return nbs.mt.addChunk(h, data)
 	}
 	return true
 }
 
-func (nbs *NomsBlockStore) Get(h hash.Hash) chunks.Chunk {
+func (nbs *NomsBlockStore) Get(ctx context.Context, h hash.Hash) chunks.Chunk {
 	a := addr(h)
+	if data := nbs.wb.get(a); data != nil {
+		return chunks.NewChunkWithHash(h, data)
+	}
 	data, tables := func() (data []byte, tables chunkReader) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
@@ -157,13 +207,17 @@ func (nbs *NomsBlockStore) Get(h hash.Hash) chunks.Chunk {
 	if data != nil {
 		return chunks.NewChunkWithHash(h, data)
 	}
-	if data := tables.get(a); data != nil {
+	if data := tables.get(ctx, a); data != nil {
 		return chunks.NewChunkWithHash(h, data)
 	}
 	return chunks.EmptyChunk
 }
 
-func (nbs *NomsBlockStore) GetMany(hashes []hash.Hash) []chunks.Chunk {
+// GetMany fetches the chunks identified by hashes and returns them. If ctx is
+// cancelled or its deadline is exceeded before all in-flight reads complete,
+// GetMany aborts the outstanding fan-out and returns ctx.Err() rather than
+// handing back a partially-populated (and therefore misleading) result.
+func (nbs *NomsBlockStore) GetMany(ctx context.Context, hashes []hash.Hash) ([]chunks.Chunk, error) {
 	reqs := toGetRecords(hashes)
 
 	wg := &sync.WaitGroup{}
@@ -186,8 +240,27 @@ func (nbs *NomsBlockStore) GetMany(hashes []hash.Hash) []chunks.Chunk {
 	sort.Sort(getRecordByPrefix(reqs))
 
 	if remaining {
-		tables.getMany(reqs, wg)
-		wg.Wait()
+		tables.getMany(ctx, reqs, wg)
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			// Cancellation can make both cases ready at once; fan-out
+			// goroutines that saw ctx done left their reqs unfilled.
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		case <-ctx.Done():
+			// The fan-out goroutines started by tables.getMany observe ctx
+			// themselves and will abandon their in-flight S3 range reads, but
+			// we still need to let them unwind before returning so nothing
+			// writes into reqs after we've walked away from it.
+			<-done
+			return nil, ctx.Err()
+		}
 	}
 
 	sort.Sort(getRecordByOrder(reqs))
@@ -201,7 +274,7 @@ func (nbs *NomsBlockStore) GetMany(hashes []hash.Hash) []chunks.Chunk {
 		}
 	}
 
-	return resp
+	return resp, nil
 }
 
 func toGetRecords(hashes []hash.Hash) []getRecord {
@@ -234,32 +307,51 @@ func (nbs *NomsBlockStore) CalcReads(hashes []hash.Hash, blockSize, maxReadSize,
 	return
 }
 
-func (nbs *NomsBlockStore) extractChunks(order EnumerationOrder, chunkChan chan<- *chunks.Chunk) {
+// ReadStats returns a snapshot of the read path's Prometheus-style
+// counters -- bytes requested vs. fetched, a coalesced-range-size
+// histogram, and time spent waiting on the ReadConfig semaphores --
+// accumulated since the store was created.
+func (nbs *NomsBlockStore) ReadStats() ReadStats {
+	nbs.mu.RLock()
+	tables := nbs.tables
+	nbs.mu.RUnlock()
+	return tables.readStats()
+}
+
+func (nbs *NomsBlockStore) extractChunks(ctx context.Context, order EnumerationOrder, chunkChan chan<- *chunks.Chunk) {
 	ch := make(chan extractRecord, 1)
 	go func() {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
 		// Chunks in nbs.tables were inserted before those in nbs.mt, so extract chunks there _first_ if we're doing InsertOrder...
 		if order == InsertOrder {
-			nbs.tables.extract(order, ch)
+			nbs.tables.extract(ctx, order, ch)
 		}
 		if nbs.mt != nil {
 			nbs.mt.extract(order, ch)
 		}
 		// ...and do them _second_ if we're doing ReverseOrder
 		if order == ReverseOrder {
-			nbs.tables.extract(order, ch)
+			nbs.tables.extract(ctx, order, ch)
 		}
 
 		close(ch)
 	}()
-	for rec := range ch {
-		c := chunks.NewChunkWithHash(hash.Hash(rec.a), rec.data)
-		chunkChan <- &c
+	for {
+		select {
+		case rec, ok := <-ch:
+			if !ok {
+				return
+			}
+			c := chunks.NewChunkWithHash(hash.Hash(rec.a), rec.data)
+			chunkChan <- &c
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func (nbs *NomsBlockStore) Count() uint32 {
+func (nbs *NomsBlockStore) Count(ctx context.Context) uint32 {
 	count, tables := func() (count uint32, tables chunkReader) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
@@ -268,17 +360,20 @@ func (nbs *NomsBlockStore) Count() uint32 {
 		}
 		return count, nbs.tables
 	}()
-	return count + tables.count()
+	return count + tables.count(ctx)
 }
 
-func (nbs *NomsBlockStore) Has(h hash.Hash) bool {
+func (nbs *NomsBlockStore) Has(ctx context.Context, h hash.Hash) bool {
 	a := addr(h)
+	if nbs.wb.has(a) {
+		return true
+	}
 	has, tables := func() (bool, chunkReader) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
 		return nbs.mt != nil && nbs.mt.has(a), nbs.tables
 	}()
-	return has || tables.has(a)
+	return has || tables.has(ctx, a)
 }
 
 func (nbs *NomsBlockStore) Root() hash.Hash {
@@ -287,17 +382,17 @@ func (nbs *NomsBlockStore) Root() hash.Hash {
 	return nbs.root
 }
 
-func (nbs *NomsBlockStore) UpdateRoot(current, last hash.Hash) bool {
+func (nbs *NomsBlockStore) UpdateRoot(ctx context.Context, current, last hash.Hash) bool {
 	nbs.mu.Lock()
 	defer nbs.mu.Unlock()
 	d.Chk.True(nbs.root == last, "UpdateRoot: last != nbs.Root(); %s != %s", last, nbs.root)
 
 	if nbs.mt != nil && nbs.mt.count() > 0 {
-		nbs.tables = nbs.tables.Prepend(nbs.mt)
+		nbs.tables = nbs.tables.Prepend(ctx, nbs.mt)
 		nbs.mt = nil
 	}
 
-	actual, tableNames := nbs.mm.Update(nbs.tables.ToSpecs(), nbs.root, current, nil)
+	actual, tableNames := nbs.mm.Update(ctx, nbs.tables.ToSpecs(), nbs.root, current, nil)
 
 	if current != actual {
 		nbs.root = actual
@@ -312,10 +407,12 @@ func (nbs *NomsBlockStore) Version() string {
 	return nbs.nomsVersion
 }
 
-func (nbs *NomsBlockStore) Close() (err error) {
+func (nbs *NomsBlockStore) Close(ctx context.Context) (err error) {
+	d.Chk.NoError(nbs.wb.flush(ctx))
+	nbs.wb.close()
 	nbs.mu.Lock()
 	defer nbs.mu.Unlock()
-	return nbs.tables.Close()
+	return nbs.tables.Close(ctx)
 }
 
 // types.BatchStore
@@ -323,7 +420,16 @@ func (nbs *NomsBlockStore) AddHints(hints types.Hints) {
 	// noop
 }
 
+// WaitForWriteBack blocks until every chunk SchedulePut has accepted so far
+// has been applied to nbs.mt/nbs.tables, or until ctx is done.
+// Callers that need a guarantee stronger than read-your-writes -- e.g. that
+// a crash won't lose a chunk -- should follow it with Flush.
+func (nbs *NomsBlockStore) WaitForWriteBack(ctx context.Context) error {
+	return nbs.wb.flush(ctx)
+}
+
 func (nbs *NomsBlockStore) Flush() {
-	success := nbs.UpdateRoot(nbs.root, nbs.root)
+	d.Chk.NoError(nbs.wb.flush(context.Background()))
+	success := nbs.UpdateRoot(context.Background(), nbs.root, nbs.root)
 	d.Chk.True(success)
 }
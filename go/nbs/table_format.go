@@ -0,0 +1,138 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A table object written by objectStoreTableSet lays out its chunks as:
+//
+//	+----------------+-----+----------------+-------+--------+
+//	| Chunk Data 0   | ... | Chunk Data N   | Index | Footer |
+//	+----------------+-----+----------------+-------+--------+
+//
+// Index is tableIndexEntrySize*N bytes, one entry per chunk in the order
+// its data was written, and Footer is a single big-endian uint32 giving N
+// -- enough for a reader to locate the index (and therefore the data) in
+// one pass from the end of the object, without a separate manifest lookup
+// per table. This is deliberately simpler than the prefix-map index
+// local tables use: objectStoreTableSet backs the S3/GCS-style object
+// stores, where a table is already a single GET/PUT unit, so there's no
+// need to optimize for partial-index reads the way a locally mmap'd table
+// would.
+const (
+	// tableAddrSize is the width, in bytes, of the addr each index entry
+	// keys on -- matching the width of every addr value used elsewhere in
+	// this package.
+	tableAddrSize = 20
+
+	// tableIndexEntrySize is addr (tableAddrSize) + offset (uint64) +
+	// length (uint32).
+	tableIndexEntrySize = tableAddrSize + 8 + 4
+
+	// tableFooterSize is the trailing chunk-count field every table object
+	// ends with.
+	tableFooterSize = 4
+)
+
+// tableIndexEntry locates one chunk within a table object's data section.
+type tableIndexEntry struct {
+	offset uint64
+	length uint32
+}
+
+// tableIndex is the parsed index and footer of a single table object: the
+// offset and length of every chunk it holds, keyed by address, plus the
+// order chunks were written in (needed to support InsertOrder/ReverseOrder
+// extraction). It's the unit IndexCache caches, so a hot table's index
+// only needs to be fetched and decoded once per process.
+type tableIndex struct {
+	entries map[addr]tableIndexEntry
+	order   []addr
+}
+
+func (idx tableIndex) chunkCount() uint32 {
+	return uint32(len(idx.order))
+}
+
+// encodeTable serializes chunks, in the order given, into a table object.
+// Duplicate addresses (the same chunk written twice in one call) are kept
+// only once, matching memTable's dedup-on-addChunk semantics.
+func encodeTable(chunks []tableChunk) []byte {
+	seen := make(map[addr]bool, len(chunks))
+	data := make([]byte, 0, tableFooterSize)
+	index := make([]byte, 0, len(chunks)*tableIndexEntrySize)
+	var count uint32
+
+	for _, c := range chunks {
+		if seen[c.a] {
+			continue
+		}
+		seen[c.a] = true
+
+		entry := make([]byte, tableIndexEntrySize)
+		copy(entry, c.a[:])
+		binary.BigEndian.PutUint64(entry[tableAddrSize:], uint64(len(data)))
+		binary.BigEndian.PutUint32(entry[tableAddrSize+8:], uint32(len(c.data)))
+		index = append(index, entry...)
+
+		data = append(data, c.data...)
+		count++
+	}
+
+	footer := make([]byte, tableFooterSize)
+	binary.BigEndian.PutUint32(footer, count)
+
+	out := make([]byte, 0, len(data)+len(index)+tableFooterSize)
+	out = append(out, data...)
+	out = append(out, index...)
+	out = append(out, footer...)
+	return out
+}
+
+// tableChunk is one (address, data) pair to be written into a table
+// object by encodeTable.
+type tableChunk struct {
+	a    addr
+	data []byte
+}
+
+// decodeTableIndex parses the index and footer off the end of a table
+// object's bytes, without copying its data section -- offsets into data
+// are returned by entry, and chunk bytes are sliced out of data lazily by
+// the caller as needed.
+func decodeTableIndex(data []byte) (tableIndex, error) {
+	if len(data) < tableFooterSize {
+		return tableIndex{}, fmt.Errorf("nbs: table object is too short to contain a footer (%d bytes)", len(data))
+	}
+	count := binary.BigEndian.Uint32(data[len(data)-tableFooterSize:])
+
+	indexSize := int(count) * tableIndexEntrySize
+	indexStart := len(data) - tableFooterSize - indexSize
+	if indexStart < 0 {
+		return tableIndex{}, fmt.Errorf("nbs: table object footer claims %d chunks, too many for a %d-byte object", count, len(data))
+	}
+
+	idx := tableIndex{
+		entries: make(map[addr]tableIndexEntry, count),
+		order:   make([]addr, 0, count),
+	}
+	for i := 0; i < int(count); i++ {
+		off := indexStart + i*tableIndexEntrySize
+		var a addr
+		copy(a[:], data[off:off+tableAddrSize])
+		entry := tableIndexEntry{
+			offset: binary.BigEndian.Uint64(data[off+tableAddrSize:]),
+			length: binary.BigEndian.Uint32(data[off+tableAddrSize+8:]),
+		}
+		if _, ok := idx.entries[a]; !ok {
+			idx.order = append(idx.order, a)
+		}
+		idx.entries[a] = entry
+	}
+	return idx, nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import "testing"
+
+func TestTableRateLimitersPerTable(t *testing.T) {
+	trl := &tableRateLimiters{cap: 2, sem: map[addr]chan struct{}{}}
+
+	var a, b addr
+	a[0], b[0] = 1, 2
+
+	semA := trl.get(a)
+	if cap(semA) != 2 {
+		t.Fatalf("cap(semA) = %d, want 2", cap(semA))
+	}
+	if trl.get(a) != semA {
+		t.Fatalf("get(a) returned a different channel on a second call")
+	}
+
+	semB := trl.get(b)
+	if semB == semA {
+		t.Fatalf("two different table names shared the same semaphore")
+	}
+
+	// Filling semA to capacity must not block acquisition of semB -- a hot
+	// table can't starve reads against the rest of the store.
+	semA <- struct{}{}
+	semA <- struct{}{}
+	select {
+	case semB <- struct{}{}:
+	default:
+		t.Fatalf("semB was blocked by semA being full")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errWriteBackClosed is returned by schedulePut when it was blocked waiting
+// for the in-flight byte budget to clear and the pipeline was closed out
+// from under it.
+var errWriteBackClosed = errors.New("nbs: write-back pipeline closed")
+
+// WriteBackConfig controls the concurrency and buffering of a
+// NomsBlockStore's background write-back pipeline: the goroutines that pull
+// chunks enqueued by SchedulePut off the pending queue and drive them
+// through addChunk (and, eventually, table rotation and upload) without
+// blocking the caller.
+type WriteBackConfig struct {
+	// GoroutineCount is the number of workers draining the pending queue.
+	GoroutineCount int
+	// InFlightCount bounds how many chunks may be queued or in-flight at
+	// once, regardless of their size.
+	InFlightCount int
+	// InFlightBytes bounds the total size, in bytes, of chunks that may be
+	// queued or in-flight at once. A put is accepted whenever the total is
+	// not already over InFlightBytes, so the last accepted chunk can take
+	// the total past it.
+	InFlightBytes uint64
+}
+
+var defaultWriteBackConfig = WriteBackConfig{
+	GoroutineCount: 4,
+	InFlightCount:  256,
+	InFlightBytes:  128 * 1 << 20, // 128MB
+}
+
+func (cfg WriteBackConfig) orDefault() WriteBackConfig {
+	if cfg.GoroutineCount == 0 {
+		cfg.GoroutineCount = defaultWriteBackConfig.GoroutineCount
+	}
+	if cfg.InFlightCount == 0 {
+		cfg.InFlightCount = defaultWriteBackConfig.InFlightCount
+	}
+	if cfg.InFlightBytes == 0 {
+		cfg.InFlightBytes = defaultWriteBackConfig.InFlightBytes
+	}
+	return cfg
+}
+
+// pendingPut is one chunk that has been accepted by SchedulePut but not yet
+// placed into nbs.mt/nbs.tables.
+type pendingPut struct {
+	a    addr
+	data []byte
+	done chan error
+}
+
+// writeBackPipeline is a bounded pool of goroutines that asynchronously
+// drive SchedulePut'd chunks into a NomsBlockStore. Queueing respects both
+// InFlightCount and InFlightBytes; once either is exceeded, schedulePut
+// blocks rather than letting the queue grow without bound. It never
+// returns a chunks.BackpressureError.
+type writeBackPipeline struct {
+	cfg WriteBackConfig
+
+	addChunk func(ctx context.Context, h addr, data []byte) bool
+
+	reqC chan *pendingPut
+	wg   sync.WaitGroup
+
+	inFlightBytes uint64
+
+	mu      sync.Mutex
+	cond    *sync.Cond           // signalled whenever inFlightBytes shrinks, or the pipeline is closed
+	pending map[addr]*pendingPut // chunks enqueued but not yet applied; consulted by Get/Has
+
+	// closed is set under mu and broadcast on cond by close(), so a
+	// schedulePut call blocked waiting for backpressure to clear wakes up
+	// and gives up rather than blocking forever once the pipeline (and
+	// therefore its owning NomsBlockStore) is shutting down.
+	closed bool
+}
+
+func newWriteBackPipeline(cfg WriteBackConfig, addChunk func(ctx context.Context, h addr, data []byte) bool) *writeBackPipeline {
+	cfg = cfg.orDefault()
+	wb := &writeBackPipeline{
+		cfg:      cfg,
+		addChunk: addChunk,
+		reqC:     make(chan *pendingPut, cfg.InFlightCount),
+		pending:  map[addr]*pendingPut{},
+	}
+	wb.cond = sync.NewCond(&wb.mu)
+	for i := 0; i < cfg.GoroutineCount; i++ {
+		wb.wg.Add(1)
+		go wb.work()
+	}
+	return wb
+}
+
+func (wb *writeBackPipeline) work() {
+	defer wb.wg.Done()
+	for p := range wb.reqC {
+		wb.addChunk(context.Background(), p.a, p.data)
+
+		wb.mu.Lock()
+		if wb.pending[p.a] == p {
+			delete(wb.pending, p.a)
+		}
+		wb.inFlightBytes -= uint64(len(p.data))
+		wb.cond.Broadcast()
+		wb.mu.Unlock()
+
+		close(p.done)
+	}
+}
+
+// schedulePut enqueues c for asynchronous write-back, returning a channel
+// that's closed once the chunk has been applied. If the in-flight
+// byte budget is exceeded, schedulePut blocks until enough room frees up
+// rather than letting the queue, and memory, grow without bound.
+//
+// SchedulePut's signature is fixed by the chunks.ChunkStore interface it
+// implements and takes no ctx, so the caller-supplied ctx here is always
+// context.Background() in practice -- schedulePut can't be cancelled or
+// given a deadline by its caller. What it can observe is the pipeline's own
+// lifetime: close() wakes every blocked schedulePut call so a stalled
+// upload or wedged worker doesn't leave a goroutine parked here forever
+// once the store is shutting down. A SchedulePut that blocks on
+// backpressure while the store stays open and the stall persists has no
+// way out short of that stall clearing; callers that need a hard timeout
+// on SchedulePut itself should run it in its own goroutine and select
+// against their own deadline.
+func (wb *writeBackPipeline) schedulePut(ctx context.Context, a addr, data []byte) chan error {
+	wb.mu.Lock()
+	for wb.inFlightBytes > wb.cfg.InFlightBytes {
+		if wb.closed {
+			wb.mu.Unlock()
+			return erroredDone(errWriteBackClosed)
+		}
+		if ctx.Err() != nil {
+			wb.mu.Unlock()
+			return erroredDone(ctx.Err())
+		}
+		wb.cond.Wait()
+	}
+	wb.inFlightBytes += uint64(len(data))
+	p := &pendingPut{a: a, data: data, done: make(chan error)}
+	wb.pending[a] = p
+	wb.mu.Unlock()
+
+	wb.reqC <- p
+	return p.done
+}
+
+func erroredDone(err error) chan error {
+	done := make(chan error, 1)
+	done <- err
+	close(done)
+	return done
+}
+
+// get returns the data for a if it's still sitting in the pending queue,
+// not yet applied to nbs.mt/nbs.tables, so that Get/Has observe writes
+// SchedulePut has accepted but not yet flushed through.
+func (wb *writeBackPipeline) get(a addr) []byte {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if p, ok := wb.pending[a]; ok {
+		return p.data
+	}
+	return nil
+}
+
+func (wb *writeBackPipeline) has(a addr) bool {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	_, ok := wb.pending[a]
+	return ok
+}
+
+// flush blocks until every chunk enqueued before this call has been
+// applied, or ctx is done.
+func (wb *writeBackPipeline) flush(ctx context.Context) error {
+	wb.mu.Lock()
+	pending := make([]*pendingPut, 0, len(wb.pending))
+	for _, p := range wb.pending {
+		pending = append(pending, p)
+	}
+	wb.mu.Unlock()
+
+	for _, p := range pending {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (wb *writeBackPipeline) close() {
+	wb.mu.Lock()
+	wb.closed = true
+	wb.cond.Broadcast()
+	wb.mu.Unlock()
+
+	close(wb.reqC)
+	wb.wg.Wait()
+}
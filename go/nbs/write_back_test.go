@@ -0,0 +1,65 @@
+// Copyright 2016 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSchedulePutUnblocksOnClose pins down the fix for schedulePut's
+// backpressure wait never observing cancellation: a call blocked on a full
+// in-flight byte budget must wake up and return errWriteBackClosed once the
+// pipeline is closed, rather than blocking the caller's goroutine forever.
+func TestSchedulePutUnblocksOnClose(t *testing.T) {
+	blockAddChunk := make(chan struct{})
+	wb := newWriteBackPipeline(WriteBackConfig{GoroutineCount: 1, InFlightBytes: 1}, func(ctx context.Context, h addr, data []byte) bool {
+		<-blockAddChunk
+		return true
+	})
+
+	// Fill the in-flight byte budget with a put the single worker will
+	// block on applying, so the next schedulePut call has to wait.
+	first := wb.schedulePut(context.Background(), addr{1}, []byte("xx"))
+
+	blocked := make(chan chan error, 1)
+	go func() {
+		blocked <- wb.schedulePut(context.Background(), addr{2}, []byte("yy"))
+	}()
+
+	select {
+	case <-blocked:
+		t.Fatalf("schedulePut returned before the in-flight budget had room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// close() itself can't return until the worker currently stuck in
+	// addChunk(first) drains reqC, so run it in the background -- the
+	// second schedulePut should still unblock immediately, since that only
+	// depends on the closed flag close() sets before waiting on the
+	// workers.
+	closeDone := make(chan struct{})
+	go func() {
+		wb.close()
+		close(closeDone)
+	}()
+
+	select {
+	case done := <-blocked:
+		if err := <-done; err != errWriteBackClosed {
+			t.Fatalf("expected errWriteBackClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("schedulePut stayed blocked after close()")
+	}
+
+	close(blockAddChunk)
+	<-closeDone
+
+	if err := <-first; err != nil {
+		t.Fatalf("first schedulePut: %v", err)
+	}
+}